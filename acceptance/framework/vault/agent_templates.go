@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/acceptance/framework/environment"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// agentTemplate is one entry of the Vault Agent "template" stanza: the
+// consul-template body ctmpl, rendered into the server.hcl fragment at
+// destFile.
+type agentTemplate struct {
+	destFile string
+	ctmpl    string
+}
+
+// AgentTemplatesConfigMapName returns the name of the ConfigMap that
+// CreateAgentTemplatesConfigMap creates for releaseName, for use as
+// global.secretsBackend.vault.agentTemplates.configMapName.
+func AgentTemplatesConfigMapName(releaseName string) string {
+	return fmt.Sprintf("%s-vault-agent-templates", releaseName)
+}
+
+// AgentTemplatesConfig selects which server.hcl fragments
+// CreateAgentTemplatesConfigMap renders. Each field should only be set to
+// true once the corresponding Vault secret has been written, since Vault
+// Agent blocks rendering (and so blocks the server from becoming ready)
+// on a template whose secret doesn't exist yet.
+type AgentTemplatesConfig struct {
+	Gossip           bool
+	ReplicationToken bool
+	License          bool
+}
+
+// CreateAgentTemplatesConfigMap creates a ConfigMap holding the Vault
+// Agent templates selected by templatesCfg. It returns the ConfigMap's
+// name for use with global.secretsBackend.vault.agentTemplates.configMapName.
+//
+// This is the counterpart to the chart-rendered Vault Agent annotations
+// used elsewhere in this package: instead of one secret per annotation,
+// the server reads templated server.hcl fragments and reloads on SIGHUP
+// when Vault Agent rewrites it. Rotating the ACL replication token or
+// Enterprise license secret in Vault this way never requires restarting
+// the server, since both are re-read on reload. The gossip key fragment
+// is the exception: Serf's "encrypt" config is only consulted when an
+// agent creates a brand new keyring, so rotating a running server's
+// gossip key still requires Consul's keyring API - this template only
+// keeps server.hcl in sync for the next time the server starts fresh.
+func CreateAgentTemplatesConfigMap(t *testing.T, ctx environment.TestContext, releaseName string, templatesCfg AgentTemplatesConfig) string {
+	t.Helper()
+
+	var templates []agentTemplate
+	if templatesCfg.Gossip {
+		templates = append(templates, agentTemplate{
+			destFile: "/vault/secrets/gossip.hcl",
+			ctmpl: `{{- with secret "consul/data/secret/gossip" -}}
+encrypt = "{{ .Data.data.gossip }}"
+{{- end -}}`,
+		})
+	}
+	if templatesCfg.ReplicationToken {
+		templates = append(templates, agentTemplate{
+			destFile: "/vault/secrets/replication-token.hcl",
+			ctmpl: `{{- with secret "consul/data/secret/replication" -}}
+acl { tokens { replication = "{{ .Data.data.replication }}" } }
+{{- end -}}`,
+		})
+	}
+	if templatesCfg.License {
+		templates = append(templates, agentTemplate{
+			destFile: "/vault/secrets/license.hcl",
+			ctmpl: `{{- with secret "consul/data/secret/enterpriselicense" -}}
+license_path = "{{ .Data.data.enterpriselicense }}"
+{{- end -}}`,
+		})
+	}
+
+	data := make(map[string]string, len(templates))
+	for i, tmpl := range templates {
+		data[fmt.Sprintf("%02d.ctmpl", i)] = fmt.Sprintf("%s\n%s", tmpl.destFile, tmpl.ctmpl)
+	}
+
+	name := AgentTemplatesConfigMapName(releaseName)
+	kopts := ctx.KubectlOptions(t)
+	_, err := ctx.KubernetesClient(t).CoreV1().ConfigMaps(kopts.Namespace).Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Data:       data,
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		ctx.KubernetesClient(t).CoreV1().ConfigMaps(kopts.Namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	})
+
+	return name
+}
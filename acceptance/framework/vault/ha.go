@@ -0,0 +1,229 @@
+package vault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	terratestk8s "github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/config"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/environment"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/helpers"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/logger"
+	vapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// transitKeyName is the name of the transit key the bootstrap cluster
+// creates for auto-unsealing HA VaultClusters.
+const transitKeyName = "autounseal"
+
+// NewHAVaultCluster creates a VaultCluster that will be deployed with 3
+// replicas backed by integrated Raft storage and auto-unsealed via a
+// transit seal pointed at a single-node bootstrap Vault server. Unlike
+// NewVaultCluster, the returned cluster does not need to be manually
+// initialized or unsealed: Create does that as part of standing up the
+// bootstrap cluster and joining the Raft peers.
+func NewHAVaultCluster(t *testing.T, ctx environment.TestContext, cfg *config.TestConfig, releaseName string, helmValues map[string]string) *VaultCluster {
+	cluster := NewVaultCluster(t, ctx, cfg, releaseName, helmValues)
+	cluster.ha = true
+	cluster.bootstrap = newBootstrapCluster(t, ctx, cfg, releaseName+"-bootstrap")
+	return cluster
+}
+
+// createHA stands up the bootstrap cluster, points this cluster's Helm
+// values at its transit seal, waits for all Raft peers to report ready,
+// and confirms the cluster auto-unsealed.
+func (v *VaultCluster) createHA(t *testing.T) {
+	t.Helper()
+
+	v.bootstrap.create(t)
+
+	seal := v.bootstrap.configureTransitSeal(t, transitKeyName)
+	v.helmOptions.SetValues["server.ha.enabled"] = "true"
+	v.helmOptions.SetValues["server.ha.replicas"] = fmt.Sprintf("%d", haReplicas)
+	v.helmOptions.SetValues["server.ha.raft.enabled"] = "true"
+	v.helmOptions.SetValues["server.extraEnvironmentVars.VAULT_SEAL_TYPE"] = "transit"
+	v.helmOptions.SetValues["server.extraEnvironmentVars.VAULT_SEAL_TRANSIT_ADDRESS"] = seal.address
+	v.helmOptions.SetValues["server.extraEnvironmentVars.VAULT_SEAL_TRANSIT_TOKEN"] = seal.token
+	v.helmOptions.SetValues["server.extraEnvironmentVars.VAULT_SEAL_TRANSIT_KEY_NAME"] = seal.keyName
+
+	helm.Upgrade(t, v.helmOptions, vaultHelmChartPath, v.releaseName)
+
+	for i := 0; i < haReplicas; i++ {
+		podName := fmt.Sprintf("%s-vault-%d", v.releaseName, i)
+		terratestk8s.WaitUntilPodAvailable(t, v.kubectlOptions, podName, 120, 5*time.Second)
+	}
+
+	// Initializing any one replica bootstraps the Raft cluster and elects
+	// it leader; the rest join as followers and auto-unseal against the
+	// bootstrap cluster's transit key once they see the Raft state.
+	podName := fmt.Sprintf("%s-vault-0", v.releaseName)
+	logger.Logf(t, "initializing HA Vault cluster via %s", podName)
+	client := v.vaultClientForPod(t, podName)
+	resp, err := client.Sys().Init(&vapi.InitRequest{
+		SecretShares:    1,
+		SecretThreshold: 1,
+	})
+	require.NoError(t, err)
+	v.rootToken = resp.RootToken
+
+	logger.Log(t, "waiting for HA Vault cluster to auto-unseal and elect a leader")
+	v.WaitForUnsealed(t)
+}
+
+// WaitForUnsealed polls every replica's seal status until all of them
+// report unsealed, which for a transit-sealed HA cluster happens
+// automatically as soon as each replica can reach the bootstrap cluster.
+// Create calls it once the cluster is initialized, and callers that restart or
+// otherwise re-seal a replica call it again to wait for it to auto-unseal.
+func (v *VaultCluster) WaitForUnsealed(t *testing.T) {
+	t.Helper()
+
+	require.True(t, v.ha, "WaitForUnsealed is only meaningful for HA clusters created with NewHAVaultCluster")
+
+	for i := 0; i < haReplicas; i++ {
+		podName := fmt.Sprintf("%s-vault-%d", v.releaseName, i)
+		client := v.vaultClientForPod(t, podName)
+
+		helpers.RetryUntilTimeout(t, 2*time.Minute, 5*time.Second, func() error {
+			status, err := client.Sys().SealStatus()
+			if err != nil {
+				return err
+			}
+			if status.Sealed {
+				return fmt.Errorf("%s is still sealed", podName)
+			}
+			return nil
+		})
+	}
+}
+
+// StepDownLeader forces the current Raft leader of an HA VaultCluster to
+// give up leadership, triggering a new election. It returns the pod name
+// that was the leader before stepping down, for use with
+// WaitForNewLeader.
+func (v *VaultCluster) StepDownLeader(t *testing.T) string {
+	t.Helper()
+
+	require.True(t, v.ha, "StepDownLeader is only valid for HA clusters created with NewHAVaultCluster")
+
+	leaderPod, ok := v.findLeaderPod(t)
+	require.True(t, ok, "expected to find a Raft leader among %d replicas", haReplicas)
+
+	logger.Logf(t, "stepping down Vault Raft leader %s", leaderPod)
+	client := v.vaultClientForPod(t, leaderPod)
+	client.SetToken(v.rootToken)
+	require.NoError(t, client.Sys().StepDown())
+
+	return leaderPod
+}
+
+// WaitForNewLeader waits until the Raft cluster elects a leader whose pod
+// is different from previousLeaderPod, and returns its pod name.
+func (v *VaultCluster) WaitForNewLeader(t *testing.T, previousLeaderPod string) string {
+	t.Helper()
+
+	var newLeader string
+	helpers.RetryUntilTimeout(t, 2*time.Minute, 5*time.Second, func() error {
+		leaderPod, ok := v.findLeaderPod(t)
+		if !ok {
+			return fmt.Errorf("no Raft leader elected yet")
+		}
+		if leaderPod == previousLeaderPod {
+			return fmt.Errorf("%s is still the Raft leader", leaderPod)
+		}
+		newLeader = leaderPod
+		return nil
+	})
+
+	return newLeader
+}
+
+// findLeaderPod returns the pod name of the current Raft leader, and
+// false if none of the replicas currently believe they're the leader
+// (e.g. mid-election).
+func (v *VaultCluster) findLeaderPod(t *testing.T) (string, bool) {
+	t.Helper()
+
+	for i := 0; i < haReplicas; i++ {
+		podName := fmt.Sprintf("%s-vault-%d", v.releaseName, i)
+		client := v.vaultClientForPod(t, podName)
+		client.SetToken(v.rootToken)
+
+		leader, err := client.Sys().Leader()
+		if err != nil {
+			continue
+		}
+		if leader.IsSelf {
+			return podName, true
+		}
+	}
+
+	return "", false
+}
+
+// bootstrapCluster is a single-node, Shamir-sealed Vault server used only
+// to hold the transit key that auto-unseals an HA VaultCluster.
+type bootstrapCluster struct {
+	inner *VaultCluster
+}
+
+func newBootstrapCluster(t *testing.T, ctx environment.TestContext, cfg *config.TestConfig, releaseName string) *bootstrapCluster {
+	return &bootstrapCluster{
+		inner: NewVaultCluster(t, ctx, cfg, releaseName, map[string]string{}),
+	}
+}
+
+func (b *bootstrapCluster) create(t *testing.T) {
+	b.inner.Create(t, b.inner.ctx)
+}
+
+func (b *bootstrapCluster) destroy(t *testing.T) {
+	b.inner.Destroy(t)
+}
+
+// transitSeal describes the transit seal configuration an HA cluster needs
+// in order to auto-unseal against this bootstrap cluster.
+type transitSeal struct {
+	address string
+	token   string
+	keyName string
+}
+
+// configureTransitSeal enables the transit secrets engine on the bootstrap
+// cluster, creates the named encryption key, and issues an orphan,
+// periodic token scoped to using (but not managing) that key.
+func (b *bootstrapCluster) configureTransitSeal(t *testing.T, keyName string) transitSeal {
+	t.Helper()
+
+	client := b.inner.VaultClient(t)
+
+	require.NoError(t, client.Sys().Mount("transit", &vapi.MountInput{Type: "transit"}))
+	_, err := client.Logical().Write(fmt.Sprintf("transit/keys/%s", keyName), nil)
+	require.NoError(t, err)
+
+	policyName := "autounseal"
+	require.NoError(t, client.Sys().PutPolicy(policyName, fmt.Sprintf(`
+path "transit/encrypt/%s" {
+  capabilities = ["update"]
+}
+path "transit/decrypt/%s" {
+  capabilities = ["update"]
+}
+`, keyName, keyName)))
+
+	secret, err := client.Auth().Token().Create(&vapi.TokenCreateRequest{
+		Policies: []string{policyName},
+		Period:   "768h",
+		NoParent: true,
+	})
+	require.NoError(t, err)
+
+	return transitSeal{
+		address: b.inner.Address(),
+		token:   secret.Auth.ClientToken,
+		keyName: keyName,
+	}
+}
@@ -0,0 +1,263 @@
+// Package vault contains helpers for deploying and configuring a Vault
+// server (or cluster) in Kubernetes for use by acceptance tests that
+// exercise Consul's Vault secrets backend integration.
+package vault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	terratestk8s "github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/config"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/environment"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/helpers"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/logger"
+	vapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	vaultHelmChartPath = "hashicorp/vault"
+
+	// haReplicas is the number of Vault server replicas deployed when a
+	// VaultCluster is created in HA mode with integrated Raft storage.
+	haReplicas = 3
+)
+
+// VaultCluster represents a Vault installation in a Kubernetes cluster,
+// deployed and managed via the Vault Helm chart.
+type VaultCluster struct {
+	ctx            environment.TestContext
+	helmOptions    *helm.Options
+	releaseName    string
+	kubectlOptions *terratestk8s.KubectlOptions
+	values         map[string]string
+
+	kubernetesClient kubernetes.Interface
+
+	// ha is set when this cluster was created with NewHAVaultCluster and
+	// is backed by integrated Raft storage and auto-unseal rather than a
+	// single Shamir-sealed server.
+	ha bool
+
+	// bootstrap is the single-node Vault server used to auto-unseal this
+	// cluster via the transit seal. It is nil for non-HA clusters, which
+	// are unsealed directly with their own Shamir keys.
+	bootstrap *bootstrapCluster
+
+	unsealKeys []string
+	rootToken  string
+
+	// namespace is the Vault Enterprise namespace this cluster's own
+	// clients (VaultClient) operate in. It's always empty for a
+	// VaultCluster returned by NewVaultCluster/NewHAVaultCluster, which
+	// always talk to the root namespace; CreateNamespace returns clients
+	// scoped to a namespace underneath it.
+	namespace string
+}
+
+// defaultValues are the Helm values applied to every VaultCluster unless
+// overridden by the caller.
+func defaultValues() map[string]string {
+	return map[string]string{
+		"server.dev.enabled": "false",
+		"injector.enabled":   "true",
+	}
+}
+
+// NewVaultCluster creates a VaultCluster that will be deployed as a single
+// Shamir-sealed server. Create initializes and unseals it.
+func NewVaultCluster(t *testing.T, ctx environment.TestContext, cfg *config.TestConfig, releaseName string, helmValues map[string]string) *VaultCluster {
+	kopts := ctx.KubectlOptions(t)
+	values := helpers.MergeMaps(defaultValues(), helmValues)
+
+	helmOpts := &helm.Options{
+		KubectlOptions: kopts,
+		SetValues:      values,
+		Logger:         logger.TestLogger{},
+	}
+
+	return &VaultCluster{
+		ctx:              ctx,
+		helmOptions:      helmOpts,
+		releaseName:      releaseName,
+		kubectlOptions:   kopts,
+		values:           values,
+		kubernetesClient: ctx.KubernetesClient(t),
+	}
+}
+
+// Create installs the Vault Helm chart and, for non-HA clusters,
+// initializes and unseals the resulting single server.
+func (v *VaultCluster) Create(t *testing.T, ctx environment.TestContext) {
+	t.Helper()
+
+	helpers.CheckForPriorInstallations(t, v.kubernetesClient, v.helmOptions, "app=vault", "component=server")
+
+	helpers.Cleanup(t, v.kubectlOptions != nil, func() {
+		helm.Delete(t, v.helmOptions, v.releaseName, true)
+	})
+
+	helm.Install(t, v.helmOptions, vaultHelmChartPath, v.releaseName)
+
+	terratestk8s.WaitUntilNumPodsCreated(t, v.kubectlOptions, metaLabelSelector(v.releaseName), 1, 30, 10*time.Second)
+
+	if v.ha {
+		v.createHA(t)
+		return
+	}
+
+	podName := fmt.Sprintf("%s-vault-0", v.releaseName)
+	terratestk8s.WaitUntilPodAvailable(t, v.kubectlOptions, podName, 60, 5*time.Second)
+
+	client := v.vaultClientForPod(t, podName)
+	v.initAndUnseal(t, client, podName)
+}
+
+// initAndUnseal initializes the Vault server running in podName via client
+// and unseals it, storing the root token and unseal keys on the cluster.
+func (v *VaultCluster) initAndUnseal(t *testing.T, client *vapi.Client, podName string) {
+	t.Helper()
+
+	logger.Logf(t, "initializing Vault server %s", podName)
+	resp, err := client.Sys().Init(&vapi.InitRequest{
+		SecretShares:    1,
+		SecretThreshold: 1,
+	})
+	require.NoError(t, err)
+
+	v.rootToken = resp.RootToken
+	v.unsealKeys = resp.Keys
+	client.SetToken(v.rootToken)
+
+	logger.Logf(t, "unsealing Vault server %s", podName)
+	_, err = client.Sys().Unseal(v.unsealKeys[0])
+	require.NoError(t, err)
+}
+
+// Destroy uninstalls the Vault Helm release, including its bootstrap
+// cluster, if any.
+func (v *VaultCluster) Destroy(t *testing.T) {
+	t.Helper()
+
+	helm.Delete(t, v.helmOptions, v.releaseName, true)
+	if v.bootstrap != nil {
+		v.bootstrap.destroy(t)
+	}
+}
+
+// Address returns the in-cluster address of the Vault server.
+func (v *VaultCluster) Address() string {
+	return fmt.Sprintf("https://%s-vault:8200", v.releaseName)
+}
+
+// VaultClient returns an authenticated Vault API client for this cluster,
+// using the root token obtained during Create.
+func (v *VaultCluster) VaultClient(t *testing.T) *vapi.Client {
+	t.Helper()
+
+	vaultCfg := vapi.DefaultConfig()
+	vaultCfg.Address = v.localAddress(t)
+
+	client, err := vapi.NewClient(vaultCfg)
+	require.NoError(t, err)
+	client.SetToken(v.rootToken)
+	if v.namespace != "" {
+		client.SetNamespace(v.namespace)
+	}
+
+	return client
+}
+
+// CreateNamespace creates the given Vault Enterprise namespace (as a child
+// of the cluster's configured namespace, if any) using the root client, and
+// returns a client scoped to it. Against OSS Vault builds, which don't
+// support the sys/namespaces endpoint, the write fails and so does the test.
+func (v *VaultCluster) CreateNamespace(t *testing.T, name string) *vapi.Client {
+	t.Helper()
+
+	root := v.VaultClient(t)
+	_, err := root.Logical().Write(fmt.Sprintf("sys/namespaces/%s", name), nil)
+	require.NoError(t, err)
+
+	child := v.VaultClient(t)
+	if v.namespace != "" {
+		child.SetNamespace(fmt.Sprintf("%s/%s", v.namespace, name))
+	} else {
+		child.SetNamespace(name)
+	}
+
+	return child
+}
+
+// localAddress returns the address this test process should use to reach
+// the cluster's Vault server, forwarding a local port if necessary.
+func (v *VaultCluster) localAddress(t *testing.T) string {
+	t.Helper()
+
+	localPort := terratestk8s.GetAvailablePort(t)
+	tunnel := terratestk8s.NewTunnelWithLogger(
+		v.kubectlOptions,
+		terratestk8s.ResourceTypePod,
+		fmt.Sprintf("%s-vault-0", v.releaseName),
+		localPort,
+		8200,
+		logger.TestLogger{},
+	)
+	t.Cleanup(tunnel.Close)
+	tunnel.ForwardPort(t)
+
+	return fmt.Sprintf("https://127.0.0.1:%d", localPort)
+}
+
+func (v *VaultCluster) vaultClientForPod(t *testing.T, podName string) *vapi.Client {
+	t.Helper()
+
+	localPort := terratestk8s.GetAvailablePort(t)
+	tunnel := terratestk8s.NewTunnelWithLogger(
+		v.kubectlOptions,
+		terratestk8s.ResourceTypePod,
+		podName,
+		localPort,
+		8200,
+		logger.TestLogger{},
+	)
+	t.Cleanup(tunnel.Close)
+	tunnel.ForwardPort(t)
+
+	vaultCfg := vapi.DefaultConfig()
+	vaultCfg.Address = fmt.Sprintf("https://127.0.0.1:%d", localPort)
+	client, err := vapi.NewClient(vaultCfg)
+	require.NoError(t, err)
+
+	return client
+}
+
+// CASecretName returns the name of the Kubernetes secret that the Vault
+// Helm chart populates with the server's CA certificate.
+func CASecretName(releaseName string) string {
+	return fmt.Sprintf("%s-vault-ca", releaseName)
+}
+
+// ConfigureAuthMethod configures a Kubernetes auth method in Vault so that
+// pods running under serviceAccountName in namespace can authenticate
+// using their projected service account tokens, verified against the
+// Kubernetes API server at k8sHost.
+func (v *VaultCluster) ConfigureAuthMethod(t *testing.T, client *vapi.Client, authPath, k8sHost, serviceAccountName, namespace string) {
+	t.Helper()
+
+	err := client.Sys().EnableAuthWithOptions(authPath, &vapi.EnableAuthOptions{Type: "kubernetes"})
+	require.NoError(t, err)
+
+	_, err = client.Logical().Write(fmt.Sprintf("auth/%s/config", authPath), map[string]interface{}{
+		"kubernetes_host": k8sHost,
+	})
+	require.NoError(t, err)
+}
+
+func metaLabelSelector(releaseName string) string {
+	return fmt.Sprintf("app.kubernetes.io/instance=%s,component=server", releaseName)
+}
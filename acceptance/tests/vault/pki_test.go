@@ -0,0 +1,197 @@
+package vault
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	capi "github.com/hashicorp/consul/api"
+	vapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// pkiRootPath is where the primary datacenter's root PKI is mounted.
+	// Secondary datacenters never mount a root of their own; they get an
+	// intermediate signed by this one (see configureIntermediatePKI).
+	pkiRootPath = "pki"
+
+	// primaryDatacenter is the only datacenter configurePKICA is ever
+	// called for in this test's topology.
+	primaryDatacenter = "dc1"
+
+	// connectCARootPKIPath is the PKI mount Consul's own Connect CA
+	// provider uses as its root (global.secretsBackend.vault.connectCA.rootPKIPath
+	// in vault_wan_fed_test.go). It's a separate mount from pkiRootPath,
+	// which only backs server TLS certificates.
+	connectCARootPKIPath = "connect_root"
+
+	pkiMaxTTL = "87600h"
+)
+
+// configurePKICA mounts and initializes the root PKI engine that backs
+// Consul server TLS certificates. It should only ever be configured
+// against the primary datacenter's Vault client: secondary datacenters
+// get an intermediate mount signed by this root instead of a root of
+// their own, so that every server certificate in the federation chains
+// up to a single trusted CA.
+func configurePKICA(t *testing.T, client *vapi.Client) {
+	t.Helper()
+
+	err := client.Sys().Mount(pkiRootPath, &vapi.MountInput{
+		Type:   "pki",
+		Config: vapi.MountConfigInput{MaxLeaseTTL: pkiMaxTTL},
+	})
+	require.NoError(t, err)
+
+	_, err = client.Logical().Write(fmt.Sprintf("%s/root/generate/internal", pkiRootPath), map[string]interface{}{
+		"common_name": "Consul CA",
+		"ttl":         pkiMaxTTL,
+	})
+	require.NoError(t, err)
+}
+
+// configureIntermediatePKI mounts a PKI engine in dc's Vault namespace
+// (via dcClient), unless one is already mounted there, and has the primary (via rootClient) sign its
+// intermediate CA certificate via pki/root/sign-intermediate. This keeps
+// the primary's root PKI as the single source of trust without requiring
+// secondary datacenters to hold a copy of the root's private key, and
+// lets a secondary's intermediate be rotated independently of the root
+// (see rotateIntermediatePKI).
+func configureIntermediatePKI(t *testing.T, rootClient, dcClient *vapi.Client, dc string) {
+	t.Helper()
+
+	intermediatePath := intermediatePKIPath(dc)
+
+	mounts, err := dcClient.Sys().ListMounts()
+	require.NoError(t, err)
+	if _, mounted := mounts[intermediatePath+"/"]; !mounted {
+		err = dcClient.Sys().Mount(intermediatePath, &vapi.MountInput{
+			Type:   "pki",
+			Config: vapi.MountConfigInput{MaxLeaseTTL: pkiMaxTTL},
+		})
+		require.NoError(t, err)
+	}
+
+	csrResp, err := dcClient.Logical().Write(fmt.Sprintf("%s/intermediate/generate/internal", intermediatePath), map[string]interface{}{
+		"common_name": fmt.Sprintf("Consul CA %s Intermediate", dc),
+	})
+	require.NoError(t, err)
+	csr, ok := csrResp.Data["csr"].(string)
+	require.True(t, ok, "expected a CSR in the intermediate generation response")
+
+	signResp, err := rootClient.Logical().Write(fmt.Sprintf("%s/root/sign-intermediate", pkiRootPath), map[string]interface{}{
+		"csr":    csr,
+		"format": "pem_bundle",
+		"ttl":    pkiMaxTTL,
+	})
+	require.NoError(t, err)
+	signedCert, ok := signResp.Data["certificate"].(string)
+	require.True(t, ok, "expected a signed certificate in the sign-intermediate response")
+
+	_, err = dcClient.Logical().Write(fmt.Sprintf("%s/intermediate/set-signed", intermediatePath), map[string]interface{}{
+		"certificate": signedCert,
+	})
+	require.NoError(t, err)
+}
+
+// rotateIntermediatePKI signs a fresh intermediate certificate for dc and
+// only then revokes the one it replaces, so that there's no window where
+// dc has no valid intermediate to issue server certificates from.
+func rotateIntermediatePKI(t *testing.T, rootClient, dcClient *vapi.Client, dc string) {
+	t.Helper()
+
+	oldSerial := intermediateCertSerial(t, dcClient, intermediatePKIPath(dc))
+
+	configureIntermediatePKI(t, rootClient, dcClient, dc)
+
+	_, err := rootClient.Logical().Write(fmt.Sprintf("%s/revoke", pkiRootPath), map[string]interface{}{
+		"serial_number": oldSerial,
+	})
+	require.NoError(t, err)
+}
+
+// intermediateCertSerial returns the colon-separated hex serial number of
+// the certificate currently active at intermediatePath, in the format
+// Vault's revoke endpoint expects.
+func intermediateCertSerial(t *testing.T, client *vapi.Client, intermediatePath string) string {
+	t.Helper()
+
+	secret, err := client.Logical().Read(fmt.Sprintf("%s/cert/ca", intermediatePath))
+	require.NoError(t, err)
+	require.NotNil(t, secret, "expected an active intermediate certificate at %s/cert/ca", intermediatePath)
+
+	certPEM, ok := secret.Data["certificate"].(string)
+	require.True(t, ok)
+
+	block, _ := pem.Decode([]byte(certPEM))
+	require.NotNil(t, block, "expected a PEM-encoded certificate")
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	hexBytes := fmt.Sprintf("%x", cert.SerialNumber.Bytes())
+	var serial strings.Builder
+	for i := 0; i < len(hexBytes); i += 2 {
+		if i > 0 {
+			serial.WriteByte(':')
+		}
+		serial.WriteString(hexBytes[i : i+2])
+	}
+	return serial.String()
+}
+
+// configurePKICertificates creates a role on the PKI mount backing dc
+// (the shared root for the primary, or dc's own signed intermediate for
+// a secondary) and returns the Vault path that issues server
+// certificates from it, for use as server.serverCert.secretName.
+func configurePKICertificates(t *testing.T, client *vapi.Client, consulReleaseName, ns, dc string) string {
+	t.Helper()
+
+	mountPath := pkiRootPath
+	if dc != primaryDatacenter {
+		mountPath = intermediatePKIPath(dc)
+	}
+
+	roleName := fmt.Sprintf("consul-server-%s", dc)
+	_, err := client.Logical().Write(fmt.Sprintf("%s/roles/%s", mountPath, roleName), map[string]interface{}{
+		"allow_any_name": true,
+		"max_ttl":        pkiMaxTTL,
+	})
+	require.NoError(t, err)
+
+	return fmt.Sprintf("%s/issue/%s", mountPath, roleName)
+}
+
+// verifyLeafCertsChainToRoot checks that the active Connect CA root
+// Consul reports via client is the same certificate as the primary's
+// connect_root Vault PKI root (read via rootClient), i.e. that Connect leaf certs still chain up to the primary
+// root rather than to a secondary's (rotated) intermediate.
+func verifyLeafCertsChainToRoot(t *testing.T, client *capi.Client, rootClient *vapi.Client) {
+	t.Helper()
+
+	caRoots, _, err := client.Connect().CARoots(nil)
+	require.NoError(t, err)
+
+	var active *capi.CARoot
+	for _, root := range caRoots.Roots {
+		if root.Active {
+			active = root
+		}
+	}
+	require.NotNil(t, active, "expected an active Connect CA root")
+
+	vaultRoot, err := rootClient.Logical().Read(fmt.Sprintf("%s/cert/ca", connectCARootPKIPath))
+	require.NoError(t, err)
+	require.NotNil(t, vaultRoot, "expected an active root at %s/cert/ca", connectCARootPKIPath)
+	vaultRootPEM, ok := vaultRoot.Data["certificate"].(string)
+	require.True(t, ok)
+
+	require.Equal(t, strings.TrimSpace(vaultRootPEM), strings.TrimSpace(active.RootCert),
+		"expected Consul's active Connect CA root to be the primary's connect_root PKI root")
+}
+
+func intermediatePKIPath(dc string) string {
+	return fmt.Sprintf("%s/pki_int", dc)
+}
@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	terratestk8s "github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/consul"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/helpers"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/k8s"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/logger"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/vault"
+	vapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that rotating the gossip key stored in Vault, backing a server
+// configured with global.secretsBackend.vault.agentTemplates.enabled,
+// can be rolled out live without restarting the server.
+//
+// Serf's "encrypt" config (which is all the Vault Agent-rendered
+// server.hcl fragment and its SIGHUP reload actually update) is only
+// ever consulted when an agent creates a brand new keyring; a running
+// agent's gossip keyring is runtime state that's rotated through
+// Consul's keyring API instead. So this test writes the new key to
+// Vault - keeping server.hcl in sync for any future restart - and then
+// performs the actual live rotation via the keyring API, asserting that
+// never requires restarting the server.
+func TestVault_AgentTemplatesGossipRotation(t *testing.T) {
+	cfg := suite.Config()
+	ctx := suite.Environment().DefaultContext(t)
+	ns := ctx.KubectlOptions(t).Namespace
+
+	vaultReleaseName := helpers.RandomName()
+	consulReleaseName := helpers.RandomName()
+
+	vaultCluster := vault.NewVaultCluster(t, ctx, cfg, vaultReleaseName, map[string]string{})
+	vaultCluster.Create(t, ctx)
+	vaultClient := vaultCluster.VaultClient(t)
+
+	configureGossipVaultSecret(t, vaultClient)
+	configureKubernetesAuthRoles(t, vaultClient, consulReleaseName, ns, "kubernetes", "dc1", cfg)
+	configurePKICA(t, vaultClient)
+	serverCertPath := configurePKICertificates(t, vaultClient, consulReleaseName, ns, primaryDatacenter)
+
+	templatesConfigMap := vault.CreateAgentTemplatesConfigMap(t, ctx, vaultReleaseName, vault.AgentTemplatesConfig{Gossip: true})
+
+	consulHelmValues := map[string]string{
+		"global.datacenter": "dc1",
+
+		"global.tls.enabled":           "true",
+		"global.tls.enableAutoEncrypt": "true",
+		"global.tls.caCert.secretName": "pki/cert/ca",
+		"server.serverCert.secretName": serverCertPath,
+
+		"global.gossipEncryption.secretName": "consul/data/secret/gossip",
+		"global.gossipEncryption.secretKey":  "gossip",
+
+		"global.secretsBackend.vault.enabled":                      "true",
+		"global.secretsBackend.vault.consulServerRole":             "consul-server",
+		"global.secretsBackend.vault.consulClientRole":             "consul-client",
+		"global.secretsBackend.vault.consulCARole":                 "consul-ca",
+		"global.secretsBackend.vault.ca.secretName":                vault.CASecretName(vaultReleaseName),
+		"global.secretsBackend.vault.ca.secretKey":                 "tls.crt",
+		"global.secretsBackend.vault.agentTemplates.enabled":       "true",
+		"global.secretsBackend.vault.agentTemplates.configMapName": templatesConfigMap,
+	}
+
+	consulCluster := consul.NewHelmCluster(t, consulHelmValues, ctx, cfg, consulReleaseName)
+	consulCluster.Create(t)
+	consulClient := consulCluster.SetupConsulClient(t, false)
+
+	serverPod := fmt.Sprintf("%s-consul-server-0", consulReleaseName)
+	kopts := ctx.KubectlOptions(t)
+
+	restartCountBefore := serverRestartCount(t, kopts, serverPod)
+
+	oldKeyring, err := consulClient.Operator().KeyringList(nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, oldKeyring, "expected at least one gossip keyring response")
+
+	logger.Log(t, "writing a new gossip key to Vault so server.hcl stays in sync for any future restart")
+	newKey := rotateGossipVaultSecret(t, vaultClient)
+
+	logger.Log(t, "installing and switching to the new gossip key via Consul's keyring API")
+	require.NoError(t, consulClient.Operator().KeyringInstall(newKey, nil))
+	require.NoError(t, consulClient.Operator().KeyringUse(newKey, nil))
+
+	logger.Log(t, "waiting for every member to report the new key as primary")
+	retry.RunWith(&retry.Timer{Timeout: 2 * time.Minute, Wait: 5 * time.Second}, t, func(r *retry.R) {
+		responses, err := consulClient.Operator().KeyringList(nil)
+		if err != nil {
+			r.Errorf("listing keyring: %s", err)
+			return
+		}
+		for _, resp := range responses {
+			if resp.PrimaryKeys[newKey] != resp.NumNodes {
+				r.Errorf("only %d/%d nodes have switched to the new primary key", resp.PrimaryKeys[newKey], resp.NumNodes)
+			}
+		}
+	})
+
+	for oldKey := range oldKeyring[0].Keys {
+		if oldKey == newKey {
+			continue
+		}
+		logger.Logf(t, "removing old gossip key %s", oldKey)
+		_, err := consulClient.Operator().KeyringRemove(oldKey, nil)
+		require.NoError(t, err)
+	}
+
+	restartCountAfter := serverRestartCount(t, kopts, serverPod)
+	require.Equal(t, restartCountBefore, restartCountAfter, "expected the server pod to stay up through the gossip key rotation")
+}
+
+// rotateGossipVaultSecret writes a freshly generated gossip encryption
+// key over the existing consul/data/secret/gossip secret, and returns
+// the new key so the caller can install it into Consul's live keyring.
+func rotateGossipVaultSecret(t *testing.T, client *vapi.Client) string {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	_, err = client.Logical().Write("consul/data/secret/gossip", map[string]interface{}{
+		"data": map[string]interface{}{
+			"gossip": encodedKey,
+		},
+	})
+	require.NoError(t, err)
+
+	return encodedKey
+}
+
+// serverRestartCount returns the restart count of the consul container in
+// podName, so callers can assert that a config change was picked up via
+// reload rather than a pod restart.
+func serverRestartCount(t *testing.T, kopts *terratestk8s.KubectlOptions, podName string) string {
+	t.Helper()
+
+	return strings.TrimSpace(k8s.RunKubectl(t, kopts, "get", "pod", podName,
+		"-o", "jsonpath={.status.containerStatuses[?(@.name==\"consul\")].restartCount}"))
+}
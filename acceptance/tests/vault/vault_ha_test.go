@@ -0,0 +1,127 @@
+package vault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	terratestk8s "github.com/gruntwork-io/terratest/modules/k8s"
+	capi "github.com/hashicorp/consul/api"
+
+	"github.com/hashicorp/consul-k8s/acceptance/framework/consul"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/helpers"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/k8s"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/logger"
+	"github.com/hashicorp/consul-k8s/acceptance/framework/vault"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that Consul servers and clients whose TLS certificates and
+// gossip key are sourced from an HA, auto-unsealed
+// Vault cluster recover their Vault-issued leases across a Raft leader
+// step-down followed by deleting the stepped-down pod outright, i.e.
+// that Consul keeps working both through a routine Vault leader failover
+// and through the stepped-down node coming back sealed and needing to
+// auto-unseal from scratch.
+func TestVault_HALeaderStepDown(t *testing.T) {
+	cfg := suite.Config()
+	ctx := suite.Environment().DefaultContext(t)
+	ns := ctx.KubectlOptions(t).Namespace
+
+	vaultReleaseName := helpers.RandomName()
+	consulReleaseName := helpers.RandomName()
+
+	vaultCluster := vault.NewHAVaultCluster(t, ctx, cfg, vaultReleaseName, map[string]string{})
+	vaultCluster.Create(t, ctx)
+	vaultClient := vaultCluster.VaultClient(t)
+
+	configureGossipVaultSecret(t, vaultClient)
+	configureKubernetesAuthRoles(t, vaultClient, consulReleaseName, ns, "kubernetes", "dc1", cfg)
+	configurePKICA(t, vaultClient)
+	serverCertPath := configurePKICertificates(t, vaultClient, consulReleaseName, ns, primaryDatacenter)
+
+	consulHelmValues := map[string]string{
+		"global.datacenter": "dc1",
+
+		"global.tls.enabled":           "true",
+		"global.tls.enableAutoEncrypt": "true",
+		"global.tls.caCert.secretName": "pki/cert/ca",
+		"server.serverCert.secretName": serverCertPath,
+
+		"global.gossipEncryption.secretName": "consul/data/secret/gossip",
+		"global.gossipEncryption.secretKey":  "gossip",
+
+		"connectInject.enabled": "true",
+
+		"global.secretsBackend.vault.enabled":                       "true",
+		"global.secretsBackend.vault.consulServerRole":              "consul-server",
+		"global.secretsBackend.vault.consulClientRole":              "consul-client",
+		"global.secretsBackend.vault.consulCARole":                  "consul-ca",
+		"global.secretsBackend.vault.ca.secretName":                 vault.CASecretName(vaultReleaseName),
+		"global.secretsBackend.vault.ca.secretKey":                  "tls.crt",
+		"global.secretsBackend.vault.connectCA.address":             vaultCluster.Address(),
+		"global.secretsBackend.vault.connectCA.rootPKIPath":         "connect_root",
+		"global.secretsBackend.vault.connectCA.intermediatePKIPath": "dc1/connect_inter",
+	}
+
+	consulCluster := consul.NewHelmCluster(t, consulHelmValues, ctx, cfg, consulReleaseName)
+	consulCluster.Create(t)
+
+	client := consulCluster.SetupConsulClient(t, false)
+
+	logger.Log(t, "checking that Consul servers and clients are healthy before stepping down the Vault leader")
+	requireConsulHealthy(t, client)
+
+	previousLeader := vaultCluster.StepDownLeader(t)
+
+	logger.Log(t, "waiting for a new Vault Raft leader to be elected")
+	newLeader := vaultCluster.WaitForNewLeader(t, previousLeader)
+	require.NotEqual(t, previousLeader, newLeader, "expected a different pod to become the new Raft leader")
+
+	// StepDownLeader only forces a new election; it doesn't seal the node
+	// that gave up leadership. Delete its pod outright to actually exercise
+	// a sealed replica rejoining and auto-unsealing against the bootstrap
+	// cluster.
+	kopts := ctx.KubectlOptions(t)
+	logger.Logf(t, "deleting stepped-down Vault pod %s to force a re-seal/unseal cycle", previousLeader)
+	k8s.RunKubectl(t, kopts, "delete", "pod", previousLeader, "--wait=true")
+	terratestk8s.WaitUntilPodAvailable(t, kopts, previousLeader, 120, 5*time.Second)
+
+	logger.Log(t, "waiting for the recreated Vault node to auto-unseal again")
+	vaultCluster.WaitForUnsealed(t)
+
+	logger.Log(t, "checking that Consul servers and clients recovered their Vault-issued leases")
+	helpers.RetryUntilTimeout(t, 2*time.Minute, 5*time.Second, func() error {
+		return checkConsulHealthy(client)
+	})
+}
+
+// requireConsulHealthy fails the test immediately if Consul doesn't have
+// a leader and at least one node registered in the catalog. It does not
+// look at health checks or at any sidecar.
+func requireConsulHealthy(t *testing.T, client *capi.Client) {
+	t.Helper()
+	require.NoError(t, checkConsulHealthy(client))
+}
+
+// checkConsulHealthy is the non-fatal form of requireConsulHealthy, for
+// use in a retry loop.
+func checkConsulHealthy(client *capi.Client) error {
+	leader, err := client.Status().Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return fmt.Errorf("no Consul leader elected")
+	}
+
+	nodes, _, err := client.Catalog().Nodes(nil)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no nodes registered in the catalog")
+	}
+
+	return nil
+}
@@ -25,10 +25,24 @@ import (
 // secondary cluster via a Kubernetes service. We then only need to deploy Vault agent injector
 // in the secondary that will treat the Vault server in the primary as an external server.
 func TestVault_WANFederationViaGateways(t *testing.T) {
+	testWANFederationViaGateways(t, false)
+}
+
+// Test the same topology as TestVault_WANFederationViaGateways, but with
+// dc1 and dc2's Vault secrets, PKI mounts, and Kubernetes auth methods
+// each kept in their own Vault Enterprise namespace.
+func TestVault_WANFederationViaGateways_Namespaced(t *testing.T) {
+	testWANFederationViaGateways(t, true)
+}
+
+func testWANFederationViaGateways(t *testing.T, namespaced bool) {
 	cfg := suite.Config()
 	if !cfg.EnableMultiCluster {
 		t.Skipf("skipping this test because -enable-multi-cluster is not set")
 	}
+	if namespaced && !cfg.EnableEnterprise {
+		t.Skipf("skipping this test because -enable-enterprise is not set and Vault namespaces require Vault Enterprise")
+	}
 	primaryCtx := suite.Environment().DefaultContext(t)
 	secondaryCtx := suite.Environment().Context(t, environment.SecondaryContextName)
 
@@ -66,6 +80,19 @@ func TestVault_WANFederationViaGateways(t *testing.T) {
 
 	vaultClient := primaryVaultCluster.VaultClient(t)
 
+	// In the namespaced variant, keep each datacenter's secrets, PKI
+	// mounts, and Kubernetes auth methods in their own Vault namespace so
+	// that the two datacenters can be managed independently in Vault.
+	primaryVaultNamespace := ""
+	secondaryVaultNamespace := ""
+	dc2VaultClient := vaultClient
+	if namespaced {
+		primaryVaultNamespace = "dc1"
+		secondaryVaultNamespace = "dc2"
+		vaultClient = primaryVaultCluster.CreateNamespace(t, primaryVaultNamespace)
+		dc2VaultClient = primaryVaultCluster.CreateNamespace(t, secondaryVaultNamespace)
+	}
+
 	configureGossipVaultSecret(t, vaultClient)
 
 	if cfg.EnableEnterprise {
@@ -110,21 +137,25 @@ func TestVault_WANFederationViaGateways(t *testing.T) {
 		k8sAuthMethodHost := k8s.KubernetesAPIServerHost(t, cfg, secondaryCtx)
 
 		// Now, configure the auth method in Vault.
-		secondaryVaultCluster.ConfigureAuthMethod(t, vaultClient, "kubernetes-dc2", k8sAuthMethodHost, authMethodRBACName, ns)
+		secondaryVaultCluster.ConfigureAuthMethod(t, dc2VaultClient, "kubernetes-dc2", k8sAuthMethodHost, authMethodRBACName, ns)
 	}
 
-	configureKubernetesAuthRoles(t, vaultClient, consulReleaseName, ns, "kubernetes-dc2", "dc2", cfg)
+	configureKubernetesAuthRoles(t, dc2VaultClient, consulReleaseName, ns, "kubernetes-dc2", "dc2", cfg)
 
-	// Generate a CA and create PKI roles for the primary and secondary Consul servers.
+	// Generate a root CA in the primary datacenter only. The secondary
+	// datacenter gets its own intermediate PKI mount signed by the
+	// primary's root via pki/root/sign-intermediate, rather than
+	// duplicating the root PKI in every datacenter.
 	configurePKICA(t, vaultClient)
+	configureIntermediatePKI(t, vaultClient, dc2VaultClient, "dc2")
 	primaryCertPath := configurePKICertificates(t, vaultClient, consulReleaseName, ns, "dc1")
-	secondaryCertPath := configurePKICertificates(t, vaultClient, consulReleaseName, ns, "dc2")
+	secondaryCertPath := configurePKICertificates(t, dc2VaultClient, consulReleaseName, ns, "dc2")
 
 	replicationToken := configureReplicationTokenVaultSecret(t, vaultClient, consulReleaseName, ns, "kubernetes", "kubernetes-dc2")
 
 	// Create the Vault Policy for the Connect CA in both datacenters.
 	createConnectCAPolicy(t, vaultClient, "dc1")
-	createConnectCAPolicy(t, vaultClient, "dc2")
+	createConnectCAPolicy(t, dc2VaultClient, "dc2")
 
 	// Move Vault CA secret from primary to secondary so that we can mount it to pods in the
 	// secondary cluster.
@@ -189,6 +220,14 @@ func TestVault_WANFederationViaGateways(t *testing.T) {
 		primaryConsulHelmValues["global.enterpriseLicense.secretKey"] = "enterpriselicense"
 	}
 
+	if namespaced {
+		// Every role is defined in the dc1 Vault namespace. The CA role gets
+		// a per-role override as well, since dc2 also reads the shared root's
+		// CA certificate (pki/cert/ca) from dc1's namespace.
+		primaryConsulHelmValues["global.secretsBackend.vault.namespace"] = primaryVaultNamespace
+		primaryConsulHelmValues["global.secretsBackend.vault.consulCARoleVaultNamespace"] = primaryVaultNamespace
+	}
+
 	if cfg.UseKind {
 		primaryConsulHelmValues["meshGateway.service.type"] = "NodePort"
 		primaryConsulHelmValues["meshGateway.service.nodePort"] = "30000"
@@ -252,6 +291,14 @@ func TestVault_WANFederationViaGateways(t *testing.T) {
 		secondaryConsulHelmValues["global.enterpriseLicense.secretKey"] = "enterpriselicense"
 	}
 
+	if namespaced {
+		// dc2's roles live in their own Vault namespace, except the CA role,
+		// which reads the shared root's CA certificate (pki/cert/ca) from
+		// dc1's namespace.
+		secondaryConsulHelmValues["global.secretsBackend.vault.namespace"] = secondaryVaultNamespace
+		secondaryConsulHelmValues["global.secretsBackend.vault.consulCARoleVaultNamespace"] = primaryVaultNamespace
+	}
+
 	if cfg.UseKind {
 		secondaryConsulHelmValues["meshGateway.service.type"] = "NodePort"
 		secondaryConsulHelmValues["meshGateway.service.nodePort"] = "30000"
@@ -299,6 +346,18 @@ func TestVault_WANFederationViaGateways(t *testing.T) {
 
 	logger.Log(t, "checking that connection is successful")
 	k8s.CheckStaticServerConnectionSuccessful(t, primaryCtx.KubectlOptions(t), staticClientName, "http://localhost:1234")
+
+	// Rotating dc2's intermediate PKI (signed by dc1's root) should be
+	// transparent to mTLS between datacenters, since Connect leaf certs
+	// are issued by Consul's own Connect CA, not by this PKI mount.
+	logger.Log(t, "rotating dc2's intermediate PKI")
+	rotateIntermediatePKI(t, vaultClient, dc2VaultClient, "dc2")
+
+	logger.Log(t, "checking that connection is still successful after rotating dc2's intermediate PKI")
+	k8s.CheckStaticServerConnectionSuccessful(t, primaryCtx.KubectlOptions(t), staticClientName, "http://localhost:1234")
+
+	logger.Log(t, "verifying that Connect leaf certs still chain up to the primary's root CA")
+	verifyLeafCertsChainToRoot(t, primaryClient, vaultClient)
 }
 
 // vaultAddress returns Vault's server URL depending on test configuration.
@@ -318,4 +377,4 @@ func meshGatewayAddress(t *testing.T, cfg *config.TestConfig, ctx environment.Te
 	} else {
 		return fmt.Sprintf("%s:%d", primaryMeshGWHost, 443)
 	}
-}
\ No newline at end of file
+}